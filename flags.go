@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/xitonix/flags"
+	"github.com/xitonix/flags/core"
+)
+
+// Every flag below is read in main.go via .Get()/.IsSet(). They live in one place
+// so the full set parsed by initFlags is visible at a glance instead of scattered next to each
+// feature that reads one.
+var (
+	versionRequest *core.BoolFlag
+
+	environment   *core.StringFlag
+	brokers       *core.StringSliceFlag
+	kafkaVersion  *core.StringFlag
+	logFilePath   *core.StringFlag
+	verbosity     *core.StringFlag
+	profilingMode *core.StringFlag
+
+	enableAutoTopicCreation *core.BoolFlag
+
+	enableTLS     *core.BoolFlag
+	tlsCACert     *core.StringFlag
+	tlsClientCert *core.StringFlag
+	tlsClientKey  *core.StringFlag
+
+	// sasl-mechanism/username/password predate this series; sasl-scram-algorithm/token-file/
+	// token-command were added for chunk0-2's SCRAM and OAUTHBEARER support.
+	saslMechanism      *core.StringFlag
+	saslUsername       *core.StringFlag
+	saslPassword       *core.StringFlag
+	saslScramAlgorithm *core.StringFlag
+	saslTokenFile      *core.StringFlag
+	saslTokenCommand   *core.StringFlag
+
+	// consumerGroup was added for chunk0-1's consumer-group mode.
+	consumerGroup *core.StringFlag
+
+	// offsetStoreType/DSN/Group were added for chunk0-3's pluggable OffsetStore backends.
+	offsetStoreType  *core.StringFlag
+	offsetStoreDSN   *core.StringFlag
+	offsetStoreGroup *core.StringFlag
+
+	protoDir   *core.StringFlag
+	protoFiles *core.StringSliceFlag
+
+	// decoderType/schemaRegistryURL/Auth were added for chunk0-5's schema-registry decoders.
+	decoderType        *core.StringFlag
+	schemaRegistryURL  *core.StringFlag
+	schemaRegistryAuth *core.StringFlag
+
+	interactive *core.BoolFlag
+	topic       *core.StringFlag
+	messageType *core.StringFlag
+	topicFilter *core.StringFlag
+	typeFilter  *core.StringFlag
+
+	rewind           *core.BoolFlag
+	timeCheckpoint   *core.TimeFlag
+	offsetCheckpoint *core.Int64Flag
+
+	outputDir        *core.StringFlag
+	format           *core.StringFlag
+	includeTimeStamp *core.BoolFlag
+	reverse          *core.BoolFlag
+	searchQuery      *core.StringFlag
+)
+
+// initFlags declares and parses every flag Trubka accepts.
+func initFlags() {
+	app := flags.New("trubka", "A command line tool to consume, decode and produce Kafka messages.")
+
+	versionRequest = app.Bool("version", "Prints the current version of Trubka.").WithShort("v")
+
+	environment = app.String("environment", "The environment to load the topic/type mappings for.").WithShort("e")
+	brokers = app.StringSlice("brokers", "The comma separated list of Kafka broker addresses.").WithDefault([]string{"127.0.0.1:9092"})
+	kafkaVersion = app.String("kafka-version", "The Kafka cluster version.")
+	logFilePath = app.String("log-file", "The file to write the log output to, instead of stdout.")
+	verbosity = app.String("verbosity", "The verbosity level of the output.").WithDefault("info")
+	profilingMode = app.String("profile", "Enables runtime profiling: cpu, mem, mutex, block or thread.")
+
+	enableAutoTopicCreation = app.Bool("auto-topic-creation", "Allows the consumer to create a topic if it does not already exist.")
+
+	enableTLS = app.Bool("tls", "Enables TLS connectivity to the Kafka cluster.")
+	tlsCACert = app.String("tls-ca-cert", "The path to the CA certificate file.")
+	tlsClientCert = app.String("tls-client-cert", "The path to the client TLS certificate file, for mutual authentication.")
+	tlsClientKey = app.String("tls-client-key", "The path to the client TLS key file, for mutual authentication.")
+
+	saslMechanism = app.String("sasl-mechanism", "The SASL mechanism: PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER.")
+	saslUsername = app.String("sasl-username", "The SASL username.")
+	saslPassword = app.String("sasl-password", "The SASL password.")
+	saslScramAlgorithm = app.String("sasl-scram-algorithm", "Alias for --sasl-mechanism when it is a SCRAM variant.")
+	saslTokenFile = app.String("sasl-token-file", "Path to a file holding the OAUTHBEARER access token.")
+	saslTokenCommand = app.String("sasl-token-command", "Command whose stdout is the OAUTHBEARER access token.")
+
+	consumerGroup = app.String("consumer-group", "Joins the given Kafka consumer group instead of tracking progress locally.")
+
+	offsetStoreType = app.String("offset-store", "Where to persist consumer progress: disk (default), kafka, postgres or mysql.")
+	offsetStoreDSN = app.String("offset-store-dsn", "The DSN of the database backing --offset-store=postgres|mysql.")
+	offsetStoreGroup = app.String("offset-store-group", "The consumer group ID to commit offsets under when --offset-store=kafka.")
+
+	protoDir = app.String("proto-root", "The path to the folder where the proto files live.")
+	protoFiles = app.StringSlice("proto-files", "The comma separated list of proto files to load, relative to proto-root.")
+
+	decoderType = app.String("decoder", "The message decoder to use: protobuf (default), avro, avro-sr or json-sr.")
+	schemaRegistryURL = app.String("schema-registry", "The base URL of the Confluent Schema Registry, for the avro-sr/json-sr decoders.")
+	schemaRegistryAuth = app.String("schema-registry-auth", "The value sent as the HTTP Authorization header to the Schema Registry.")
+
+	interactive = app.Bool("interactive", "Lets you pick the topics and their message types from a list.").WithShort("i")
+	topic = app.String("topic", "The topic to consume from, when not running interactively.")
+	messageType = app.String("proto", "The fully qualified name of the protobuf message of --topic.")
+	topicFilter = app.String("topic-filter", "A regular expression to filter the interactive topic list by.")
+	typeFilter = app.String("type-filter", "A regular expression to filter the interactive type list by.")
+
+	rewind = app.Bool("rewind", "Starts consuming from the beginning of each topic.")
+	timeCheckpoint = app.Time("from", "Starts consuming from the closest offset to this point in time.")
+	offsetCheckpoint = app.Int64("from-offset", "Starts consuming from this offset.")
+
+	outputDir = app.String("output-dir", "Writes the decoded output of each topic to a file in this directory, instead of stdout.")
+	format = app.String("format", "The output encoding: json (default) or text.")
+	includeTimeStamp = app.Bool("include-timestamp", "Includes the Kafka message timestamp in the output.")
+	reverse = app.Bool("reverse", "Inverts --search, printing only the messages that do not match.")
+	searchQuery = app.String("search", "A regular expression to filter the decoded output by.")
+
+	app.Parse()
+}
@@ -0,0 +1,56 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/pkg/errors"
+
+	"github.com/xitonix/trubka/protobuf"
+)
+
+// avroDecoder decodes Confluent-wire-format Avro messages into JSON. When registry is nil it
+// falls back to treating the raw value as already being schema-less Avro JSON, which is mostly
+// useful for local testing against topics produced without the wire-format envelope. Either
+// way, the decoded JSON is run through the same marshaller the protobuf decoder uses, so
+// --include-timestamp and friends behave identically regardless of wire format.
+type avroDecoder struct {
+	registry   *SchemaRegistryClient
+	marshaller *protobuf.Marshaller
+}
+
+func newAvroDecoder(registry *SchemaRegistryClient, marshaller *protobuf.Marshaller) *avroDecoder {
+	return &avroDecoder{registry: registry, marshaller: marshaller}
+}
+
+func (d *avroDecoder) Decode(_ string, value []byte, timestamp time.Time) ([]byte, error) {
+	if d.registry == nil {
+		return d.marshaller.MarshalRaw(value, timestamp)
+	}
+
+	id, payload, err := splitConfluentEnvelope(value)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := d.registry.Schema(id)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse the Avro schema with ID %d", id)
+	}
+
+	native, _, err := codec.NativeFromBinary(payload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode the Avro payload for schema ID %d", id)
+	}
+
+	output, err := codec.TextualFromNative(nil, native)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to render the Avro payload for schema ID %d as JSON", id)
+	}
+	return d.marshaller.MarshalRaw(output, timestamp)
+}
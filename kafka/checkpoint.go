@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Checkpoint determines the offset a topic's partitions will rewind to, when they have no
+// previously stored progress in the offset store. Only the standalone consumer applies it; in
+// consumer-group mode a partition with no committed offset starts from the newest offset,
+// whatever its checkpoint says.
+type Checkpoint struct {
+	rewind    bool
+	offset    int64
+	at        time.Time
+	useOffset bool
+	useTime   bool
+}
+
+// NewCheckpoint creates a new checkpoint. If rewind is true and neither SetOffset nor
+// SetTimeOffset has been called, consumption starts from the oldest available offset.
+func NewCheckpoint(rewind bool) *Checkpoint {
+	return &Checkpoint{
+		rewind: rewind,
+		offset: sarama.OffsetNewest,
+	}
+}
+
+// SetOffset pins the checkpoint to a specific, explicit offset.
+func (c *Checkpoint) SetOffset(offset int64) {
+	c.offset = offset
+	c.useOffset = true
+}
+
+// SetTimeOffset pins the checkpoint to the first message at or after the given time.
+func (c *Checkpoint) SetTimeOffset(at time.Time) {
+	c.at = at
+	c.useTime = true
+}
+
+// TopicOffset resolves the sarama starting offset for a partition with no stored progress. It
+// does not resolve a SetTimeOffset time, which needs a broker lookup; the consumer does that
+// lookup itself.
+func (c *Checkpoint) TopicOffset() int64 {
+	switch {
+	case c.useOffset:
+		return c.offset
+	case c.rewind:
+		return sarama.OffsetOldest
+	default:
+		return sarama.OffsetNewest
+	}
+}
@@ -0,0 +1,342 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+
+	"github.com/xitonix/trubka/internal"
+)
+
+// Option represents a configuration function for the consumer.
+type Option func(*Consumer)
+
+// WithClusterVersion sets the Kafka cluster version the client will negotiate against.
+func WithClusterVersion(version string) Option {
+	return func(c *Consumer) {
+		if internal.IsEmpty(version) {
+			return
+		}
+		v, err := sarama.ParseKafkaVersion(version)
+		if err != nil {
+			return
+		}
+		c.config.Version = v
+	}
+}
+
+// WithTLS enables TLS on the underlying Kafka client connection.
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(c *Consumer) {
+		if tlsConfig == nil {
+			return
+		}
+		c.config.Net.TLS.Enable = true
+		c.config.Net.TLS.Config = tlsConfig
+	}
+}
+
+// WithSASL configures SASL authentication on the consumer. mechanism accepts "PLAIN",
+// "SCRAM-SHA-256" or "SCRAM-SHA-512"; OAUTHBEARER is configured separately via
+// WithSASLOAuthBearer since it is driven by a token provider rather than a static password.
+func WithSASL(mechanism string, username string, password string) Option {
+	return func(c *Consumer) {
+		if internal.IsEmpty(mechanism) {
+			return
+		}
+		c.config.Net.SASL.Enable = true
+		c.config.Net.SASL.Mechanism = sarama.SASLMechanism(mechanism)
+		c.config.Net.SASL.User = username
+		c.config.Net.SASL.Password = password
+		if generator := scramClientGeneratorFunc(mechanism); generator != nil {
+			c.config.Net.SASL.SCRAMClientGeneratorFunc = generator
+		}
+	}
+}
+
+// WithConsumerGroup switches the consumer into consumer-group mode: partitions are assigned
+// by the Kafka group coordinator and shared with every other Trubka instance joining with the
+// same groupID, and progress is committed to `__consumer_offsets` instead of the local disk
+// store. Assignment uses the sticky strategy so repeated rebalances keep ownership as stable
+// as possible.
+func WithConsumerGroup(groupID string) Option {
+	return func(c *Consumer) {
+		if internal.IsEmpty(groupID) {
+			return
+		}
+		c.groupID = groupID
+		c.config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategySticky
+		c.config.Consumer.Offsets.AutoCommit.Enable = false
+	}
+}
+
+// WithOffsetStore overrides the OffsetStore used to track progress in standalone mode. When
+// omitted, the consumer falls back to NewDiskOffsetStore. It has no effect in consumer-group
+// mode, where progress is always committed through the group session.
+func WithOffsetStore(store OffsetStore) Option {
+	return func(c *Consumer) {
+		c.offsetStore = store
+	}
+}
+
+// commitBatchSize and commitBatchInterval debounce the OffsetCommit RPC behind MarkMessage in
+// consumer-group mode: committing after every single message would serialize consumption on
+// coordinator latency, so marks are only flushed to __consumer_offsets every commitBatchSize
+// marks or commitBatchInterval, whichever comes first.
+const (
+	commitBatchSize     = 100
+	commitBatchInterval = 3 * time.Second
+)
+
+// Consumer wraps a sarama client and drives the consumption of one or more topics, either
+// as a standalone process tracking progress in the local offset store, or as a member of a
+// consumer group tracking progress via Kafka-committed offsets.
+type Consumer struct {
+	brokers           []string
+	printer           internal.Printer
+	environment       string
+	autoTopicCreation bool
+	groupID           string
+	config            *sarama.Config
+	client            sarama.Client
+	offsetStore       OffsetStore
+	events            chan *Event
+	rebalances        chan RebalanceEvent
+	wg                sync.WaitGroup
+
+	commitMu     sync.Mutex
+	pendingMarks int
+	lastCommit   time.Time
+}
+
+// NewConsumer creates a new instance of Kafka consumer.
+func NewConsumer(
+	brokers []string,
+	printer internal.Printer,
+	environment string,
+	autoTopicCreation bool,
+	options ...Option) (*Consumer, error) {
+
+	config := sarama.NewConfig()
+	config.ClientID = "trubka"
+
+	c := &Consumer{
+		brokers:           brokers,
+		printer:           printer,
+		environment:       environment,
+		autoTopicCreation: autoTopicCreation,
+		config:            config,
+		events:            make(chan *Event, 100),
+		rebalances:        make(chan RebalanceEvent, 10),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to connect to the Kafka cluster")
+	}
+	c.client = client
+
+	return c, nil
+}
+
+// Events returns the channel on which consumed messages are delivered.
+func (c *Consumer) Events() <-chan *Event {
+	return c.events
+}
+
+// Rebalances returns the channel on which consumer-group rebalance notifications are
+// delivered. It only carries events when the consumer was created with WithConsumerGroup;
+// callers running in standalone mode can safely ignore it.
+func (c *Consumer) Rebalances() <-chan RebalanceEvent {
+	return c.rebalances
+}
+
+// Start starts consuming the given topics from the provided checkpoints. If the consumer was
+// configured with WithConsumerGroup, partitions are assigned by the group coordinator and
+// offsets are committed to Kafka, and the checkpoints are not applied; otherwise each topic is
+// consumed directly, with progress tracked in the local offset store.
+func (c *Consumer) Start(ctx context.Context, topics map[string]*Checkpoint) error {
+	if c.groupID != "" {
+		return c.startGroup(ctx, topics)
+	}
+	return c.startStandalone(ctx, topics)
+}
+
+func (c *Consumer) startStandalone(ctx context.Context, topics map[string]*Checkpoint) error {
+	if c.offsetStore == nil {
+		store, err := NewDiskOffsetStore(c.printer, filepath.Join(".trubka", "offsets", c.environment))
+		if err != nil {
+			return err
+		}
+		c.offsetStore = store
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(c.client)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create the partition consumer")
+	}
+
+	for topic, cp := range topics {
+		partitions, err := consumer.Partitions(topic)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get the partitions of topic %s", topic)
+		}
+		stored, err := c.offsetStore.Query(topic)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to load the stored offsets of topic %s", topic)
+		}
+		for _, partition := range partitions {
+			offset, ok := stored[partition]
+			if !ok {
+				offset = cp.TopicOffset()
+				if cp.useTime && !cp.useOffset {
+					offset, err = c.client.GetOffset(topic, partition, cp.at.UnixNano()/int64(time.Millisecond))
+					if err != nil {
+						return errors.Wrapf(err, "Failed to find the offset of partition %d of topic %s at %s", partition, topic, cp.at)
+					}
+				}
+			}
+			c.wg.Add(1)
+			go c.consumePartition(ctx, consumer, topic, partition, offset)
+		}
+	}
+
+	go func() {
+		for err := range c.offsetStore.Errors() {
+			c.printer.Logf(internal.Forced, "Offset store error: %s", err)
+		}
+	}()
+
+	c.wg.Wait()
+	close(c.events)
+	return nil
+}
+
+func (c *Consumer) consumePartition(ctx context.Context, consumer sarama.Consumer, topic string, partition int32, offset int64) {
+	defer c.wg.Done()
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		c.printer.Logf(internal.Forced, "Failed to consume partition %d of topic %s: %s", partition, topic, err)
+		return
+	}
+	defer pc.AsyncClose()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, more := <-pc.Messages():
+			if !more {
+				return
+			}
+			c.events <- &Event{
+				Topic:     msg.Topic,
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				Key:       msg.Key,
+				Value:     msg.Value,
+				Timestamp: msg.Timestamp,
+			}
+		}
+	}
+}
+
+func (c *Consumer) startGroup(ctx context.Context, topics map[string]*Checkpoint) error {
+	group, err := sarama.NewConsumerGroupFromClient(c.groupID, c.client)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to join the consumer group %s", c.groupID)
+	}
+
+	names := make([]string, 0, len(topics))
+	for topic := range topics {
+		names = append(names, topic)
+	}
+
+	handler := &groupHandler{consumer: c}
+
+	go func() {
+		for err := range group.Errors() {
+			c.printer.Logf(internal.Forced, "Consumer group %s error: %s", c.groupID, err)
+		}
+	}()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for ctx.Err() == nil {
+			if err := group.Consume(ctx, names, handler); err != nil {
+				if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+					return
+				}
+				c.printer.Logf(internal.Forced, "Lost membership of the consumer group %s, rejoining: %s", c.groupID, err)
+			}
+		}
+	}()
+
+	c.wg.Wait()
+	if err := group.Close(); err != nil {
+		c.printer.Logf(internal.Forced, "Failed to close the consumer group %s: %s", c.groupID, err)
+	}
+	close(c.events)
+	return nil
+}
+
+// StoreOffset marks the event's offset as processed, so it won't be re-delivered on restart.
+// In consumer-group mode this commits through the group session; otherwise it is queued up
+// for the local offset store.
+func (c *Consumer) StoreOffset(event *Event) {
+	if event.session != nil && event.raw != nil {
+		event.session.MarkMessage(event.raw, "")
+		// AutoCommit is disabled by WithConsumerGroup, so marked offsets are only ever
+		// flushed to __consumer_offsets by an explicit Commit call.
+		c.maybeCommit(event.session)
+		return
+	}
+	if c.offsetStore != nil {
+		_ = c.offsetStore.Store(event.Topic, event.Partition, event.Offset+1)
+	}
+}
+
+// maybeCommit calls session.Commit() at most once per commitBatchSize marks or
+// commitBatchInterval, whichever comes first.
+func (c *Consumer) maybeCommit(session sarama.ConsumerGroupSession) {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	c.pendingMarks++
+	if c.pendingMarks < commitBatchSize && time.Since(c.lastCommit) < commitBatchInterval {
+		return
+	}
+	session.Commit()
+	c.pendingMarks = 0
+	c.lastCommit = time.Now()
+}
+
+// Close shuts down the underlying Kafka client. It is safe to call more than once.
+func (c *Consumer) Close() {
+	if c.offsetStore != nil {
+		if flusher, ok := c.offsetStore.(Flusher); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := flusher.Flush(ctx); err != nil {
+				c.printer.Logf(internal.Forced, "Failed to flush the offset store: %s", err)
+			}
+			cancel()
+		}
+		if err := c.offsetStore.Close(); err != nil {
+			c.printer.Logf(internal.Forced, "Failed to close the offset store: %s", err)
+		}
+		c.offsetStore = nil
+	}
+	if c.client != nil && !c.client.Closed() {
+		if err := c.client.Close(); err != nil {
+			c.printer.Logf(internal.Forced, "Failed to close the Kafka client: %s", err)
+		}
+	}
+}
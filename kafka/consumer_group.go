@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/xitonix/trubka/internal"
+)
+
+// RebalanceKind describes why a RebalanceEvent was emitted.
+type RebalanceKind int
+
+const (
+	// PartitionsAssigned is emitted when the group coordinator has handed this instance a new set of claims.
+	PartitionsAssigned RebalanceKind = iota
+	// PartitionsRevoked is emitted just before this instance gives up the partitions it currently owns.
+	PartitionsRevoked
+)
+
+// RebalanceEvent reports a consumer-group rebalance affecting this Trubka instance.
+type RebalanceEvent struct {
+	Kind    RebalanceKind
+	GroupID string
+	// Claims maps each claimed topic to the partitions assigned (or, for PartitionsRevoked, the
+	// partitions being given up) to this instance.
+	Claims map[string][]int32
+}
+
+// groupHandler implements sarama.ConsumerGroupHandler on behalf of Consumer, translating
+// claimed partitions into Events and rebalances into RebalanceEvent notifications.
+type groupHandler struct {
+	consumer *Consumer
+}
+
+// Setup is called once, at the beginning of a new session, before ConsumeClaim.
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.consumer.printer.Logf(internal.Verbose,
+		"Joined the consumer group %s, assigned claims: %v", h.consumer.groupID, session.Claims())
+	h.consumer.rebalances <- RebalanceEvent{
+		Kind:    PartitionsAssigned,
+		GroupID: h.consumer.groupID,
+		Claims:  session.Claims(),
+	}
+	return nil
+}
+
+// Cleanup is called at the end of a session, once all ConsumeClaim goroutines have exited. It
+// flushes any marks maybeCommit has been debouncing, so a rebalance or shutdown never drops
+// progress that was already marked but not yet committed.
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	h.consumer.commitMu.Lock()
+	if h.consumer.pendingMarks > 0 {
+		session.Commit()
+		h.consumer.pendingMarks = 0
+		h.consumer.lastCommit = time.Now()
+	}
+	h.consumer.commitMu.Unlock()
+
+	h.consumer.rebalances <- RebalanceEvent{
+		Kind:    PartitionsRevoked,
+		GroupID: h.consumer.groupID,
+		Claims:  session.Claims(),
+	}
+	return nil
+}
+
+// ConsumeClaim forwards every message of the claimed partition to the shared events channel,
+// tagging each one with the session it was claimed under so StoreOffset can commit it back.
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, more := <-claim.Messages():
+			if !more {
+				return nil
+			}
+			h.consumer.events <- &Event{
+				Topic:     msg.Topic,
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				Key:       msg.Key,
+				Value:     msg.Value,
+				Timestamp: msg.Timestamp,
+				raw:       msg,
+				session:   session,
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+
+	"github.com/xitonix/trubka/protobuf"
+)
+
+// Decoder turns the raw value of a Kafka message into Trubka's output format, regardless of
+// the wire format the message was produced in. messageType is only meaningful to the protobuf
+// decoder; the schema-registry backed decoders resolve their schema from the message itself.
+type Decoder interface {
+	Decode(messageType string, value []byte, timestamp time.Time) ([]byte, error)
+}
+
+// NewDecoder builds the Decoder matching kind: "protobuf" (the default), "avro", "avro-sr" or
+// "json-sr". The "-sr" variants fetch their schema from registry by the 4-byte ID Confluent's
+// wire format prefixes every message with; registry may be nil for "protobuf" and "avro".
+func NewDecoder(kind string, loader *protobuf.FileLoader, marshaller *protobuf.Marshaller, registry *SchemaRegistryClient) (Decoder, error) {
+	switch kind {
+	case "", "protobuf":
+		if loader == nil || marshaller == nil {
+			return nil, errors.New("the protobuf decoder requires a file loader and a marshaller")
+		}
+		return &protobufDecoder{loader: loader, marshaller: marshaller}, nil
+	case "avro":
+		if marshaller == nil {
+			return nil, errors.New("the avro decoder requires a marshaller")
+		}
+		return newAvroDecoder(nil, marshaller), nil
+	case "avro-sr":
+		if registry == nil {
+			return nil, errors.New("the avro-sr decoder requires a schema registry client")
+		}
+		if marshaller == nil {
+			return nil, errors.New("the avro-sr decoder requires a marshaller")
+		}
+		return newAvroDecoder(registry, marshaller), nil
+	case "json-sr":
+		if registry == nil {
+			return nil, errors.New("the json-sr decoder requires a schema registry client")
+		}
+		if marshaller == nil {
+			return nil, errors.New("the json-sr decoder requires a marshaller")
+		}
+		return newJSONSchemaDecoder(registry, marshaller), nil
+	default:
+		return nil, errors.Errorf("Unknown decoder %s", kind)
+	}
+}
+
+// protobufDecoder adapts the existing FileLoader/Marshaller pair to the Decoder interface.
+type protobufDecoder struct {
+	loader     *protobuf.FileLoader
+	marshaller *protobuf.Marshaller
+}
+
+func (d *protobufDecoder) Decode(messageType string, value []byte, timestamp time.Time) ([]byte, error) {
+	msg, err := d.loader.Get(messageType)
+	if err != nil {
+		return nil, err
+	}
+	if err := proto.Unmarshal(value, msg); err != nil {
+		return nil, err
+	}
+	return d.marshaller.Marshal(msg, timestamp)
+}
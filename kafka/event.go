@@ -0,0 +1,23 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Event represents a single message consumed from a Kafka topic/partition.
+type Event struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+
+	// raw and session are only populated when the event was produced by a consumer
+	// running in consumer-group mode. They let StoreOffset commit the message back
+	// through the group coordinator instead of the local offset store.
+	raw     *sarama.ConsumerMessage
+	session sarama.ConsumerGroupSession
+}
@@ -0,0 +1,32 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/xitonix/trubka/protobuf"
+)
+
+// jsonSchemaDecoder decodes Confluent-wire-format JSON Schema messages. The payload after the
+// envelope is already plain JSON, so decoding is just a matter of stripping the prefix; the
+// schema itself is only fetched to fail fast on an unknown/unregistered ID. The payload is then
+// run through the same marshaller the protobuf decoder uses, so --include-timestamp and friends
+// behave identically regardless of wire format.
+type jsonSchemaDecoder struct {
+	registry   *SchemaRegistryClient
+	marshaller *protobuf.Marshaller
+}
+
+func newJSONSchemaDecoder(registry *SchemaRegistryClient, marshaller *protobuf.Marshaller) *jsonSchemaDecoder {
+	return &jsonSchemaDecoder{registry: registry, marshaller: marshaller}
+}
+
+func (d *jsonSchemaDecoder) Decode(_ string, value []byte, timestamp time.Time) ([]byte, error) {
+	id, payload, err := splitConfluentEnvelope(value)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.registry.Schema(id); err != nil {
+		return nil, err
+	}
+	return d.marshaller.MarshalRaw(payload, timestamp)
+}
@@ -0,0 +1,225 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+
+	"github.com/xitonix/trubka/internal"
+)
+
+// kafkaCommitInterval caps how long a Store call can sit uncommitted: Store only buffers the
+// latest offset per partition, and a background goroutine commits whatever is buffered on this
+// tick instead of making an OffsetCommit RPC on every single call.
+const kafkaCommitInterval = 3 * time.Second
+
+// KafkaOffsetStore is an OffsetStore backed by a Kafka consumer group: progress is committed
+// to `__consumer_offsets` under groupID, rather than to local disk, so it is shared by every
+// Trubka instance joining the same group and survives the machine it ran on disappearing.
+type KafkaOffsetStore struct {
+	printer     internal.Printer
+	groupID     string
+	client      sarama.Client
+	admin       sarama.ClusterAdmin
+	writeErrors chan error
+
+	mu      sync.Mutex
+	cached  map[string]map[int32]int64
+	pending map[string]map[int32]int64
+
+	flush chan chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewKafkaOffsetStore creates an OffsetStore that commits offsets to the given consumer group.
+func NewKafkaOffsetStore(printer internal.Printer, brokers []string, groupID string, config *sarama.Config) (OffsetStore, error) {
+	if internal.IsEmpty(groupID) {
+		return nil, errors.New("the Kafka offset store requires a non-empty consumer group ID")
+	}
+	if config == nil {
+		config = sarama.NewConfig()
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to connect to the Kafka cluster")
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create the cluster admin client")
+	}
+
+	s := &KafkaOffsetStore{
+		printer:     printer,
+		groupID:     groupID,
+		client:      client,
+		admin:       admin,
+		writeErrors: make(chan error, 100),
+		cached:      make(map[string]map[int32]int64),
+		pending:     make(map[string]map[int32]int64),
+		flush:       make(chan chan struct{}),
+	}
+	s.start()
+	return s, nil
+}
+
+func (s *KafkaOffsetStore) start() {
+	s.wg.Add(1)
+	ticker := time.NewTicker(kafkaCommitInterval)
+	go func() {
+		defer s.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.commitPending()
+			case done, more := <-s.flush:
+				if !more {
+					s.commitPending()
+					return
+				}
+				s.commitPending()
+				close(done)
+			}
+		}
+	}()
+}
+
+// Store buffers the partition's offset in memory; it is committed to the group coordinator by
+// the background goroutine every kafkaCommitInterval, rather than on every call.
+func (s *KafkaOffsetStore) Store(topic string, partition int32, offset int64) error {
+	if offset == sarama.OffsetOldest || offset == sarama.OffsetNewest {
+		return nil
+	}
+	s.mu.Lock()
+	if s.pending[topic] == nil {
+		s.pending[topic] = make(map[int32]int64)
+	}
+	s.pending[topic][partition] = offset
+	s.mu.Unlock()
+	return nil
+}
+
+// commitPending commits everything buffered by Store since the last tick, reporting any
+// failure on writeErrors instead of blocking the caller that buffered it.
+func (s *KafkaOffsetStore) commitPending() {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]map[int32]int64)
+	s.mu.Unlock()
+
+	for topic, partitions := range pending {
+		if err := s.commit(topic, partitions); err != nil {
+			select {
+			case s.writeErrors <- err:
+			default:
+			}
+		}
+	}
+}
+
+func (s *KafkaOffsetStore) commit(topic string, partitions map[int32]int64) error {
+	coordinator, err := s.client.Coordinator(s.groupID)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to find the coordinator of the consumer group %s", s.groupID)
+	}
+
+	request := &sarama.OffsetCommitRequest{
+		Version:       1,
+		ConsumerGroup: s.groupID,
+	}
+	for partition, offset := range partitions {
+		request.AddBlock(topic, partition, offset, 0, "")
+	}
+
+	response, err := coordinator.CommitOffset(request)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to commit the offsets of topic %s", topic)
+	}
+	if block, ok := response.Errors[topic]; ok {
+		for partition, code := range block {
+			if code != sarama.ErrNoError {
+				return errors.Wrapf(code, "Kafka rejected the offset commit for partition %d of topic %s", partition, topic)
+			}
+		}
+	}
+	return nil
+}
+
+// Flush blocks until every offset buffered by Store so far has been committed.
+func (s *KafkaOffsetStore) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case s.flush <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Query fetches the previously committed offsets of every partition of topic from the group
+// coordinator of s.groupID.
+func (s *KafkaOffsetStore) Query(topic string) (map[int32]int64, error) {
+	coordinator, err := s.client.Coordinator(s.groupID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to find the coordinator of the consumer group %s", s.groupID)
+	}
+
+	partitions, err := s.client.Partitions(topic)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get the partitions of topic %s", topic)
+	}
+
+	request := &sarama.OffsetFetchRequest{
+		ConsumerGroup: s.groupID,
+		Version:       1,
+	}
+	for _, partition := range partitions {
+		request.AddPartition(topic, partition)
+	}
+
+	response, err := coordinator.FetchOffset(request)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to fetch the committed offsets of topic %s", topic)
+	}
+
+	offsets := make(map[int32]int64)
+	for _, partition := range partitions {
+		block := response.GetBlock(topic, partition)
+		if block == nil || block.Offset < 0 {
+			continue
+		}
+		offsets[partition] = block.Offset
+	}
+	s.mu.Lock()
+	s.cached[topic] = offsets
+	s.mu.Unlock()
+	return offsets, nil
+}
+
+// Errors returns the channel on which asynchronous write errors are delivered.
+func (s *KafkaOffsetStore) Errors() <-chan error {
+	return s.writeErrors
+}
+
+// Close releases the underlying Kafka client and admin connections.
+func (s *KafkaOffsetStore) Close() error {
+	close(s.flush)
+	s.wg.Wait()
+	if err := s.admin.Close(); err != nil {
+		return errors.Wrap(err, "Failed to close the cluster admin client")
+	}
+	if !s.client.Closed() {
+		return s.client.Close()
+	}
+	return nil
+}
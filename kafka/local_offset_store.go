@@ -1,88 +1,168 @@
 package kafka
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"encoding/binary"
 	"encoding/gob"
+	"hash/crc32"
+	"io"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/Shopify/sarama"
-	"github.com/peterbourgon/diskv"
 	"github.com/pkg/errors"
 
 	"github.com/xitonix/trubka/internal"
 )
 
+const (
+	segmentFileName  = "offsets.wal"
+	snapshotFileName = "offsets.snapshot"
+
+	// fsyncRecords is the number of WAL records the store will buffer before forcing an
+	// fsync, on top of the time-based fsyncInterval tick.
+	fsyncRecords = 100
+	// compactAfterRecords bounds how large the WAL segment is allowed to grow between
+	// snapshots, so replaying it on startup stays cheap.
+	compactAfterRecords = 10000
+	fsyncInterval       = 3 * time.Second
+)
+
 type progress struct {
 	topic     string
 	partition int32
 	offset    int64
 }
 
+// localOffsetStore is a WAL-backed OffsetStore: every Store call is appended to a segment file
+// as a fixed-layout record and periodically fsync'd, so a crash can lose at most the last
+// fsyncInterval (or fsyncRecords) worth of progress, never corrupt what was already durable.
+// The segment is compacted into a snapshot, swapped in with os.Rename, once it grows too large.
 type localOffsetStore struct {
-	db          *diskv.Diskv
-	printer     internal.Printer
-	wg          sync.WaitGroup
+	printer internal.Printer
+
+	dir          string
+	segmentPath  string
+	snapshotPath string
+	segment      *os.File
+
 	writeErrors chan error
 	in          chan *progress
+	flush       chan chan struct{}
 
-	offsets map[string]map[int32]int64
-}
+	wg sync.WaitGroup
 
-func newLocalOffsetStore(printer internal.Printer, base string) (*localOffsetStore, error) {
-	printer.Logf(internal.Verbose, "Initialising local offset store at %s", base)
+	pendingSync    int
+	recordsWritten int
 
-	flatTransform := func(s string) []string { return []string{} }
+	offsetsMu sync.RWMutex
+	offsets   map[string]map[int32]int64
+}
 
-	db := diskv.New(diskv.Options{
-		BasePath:     base,
-		Transform:    flatTransform,
-		CacheSizeMax: 1024 * 1024,
-	})
+// NewDiskOffsetStore creates an OffsetStore that appends each offset update to a WAL rooted at
+// base, fsync'ing on a fixed interval and compacting into a snapshot once the WAL grows large.
+func NewDiskOffsetStore(printer internal.Printer, base string) (OffsetStore, error) {
+	printer.Logf(internal.Verbose, "Initialising the WAL offset store at %s", base)
 
-	return &localOffsetStore{
-		db:          db,
-		printer:     printer,
-		writeErrors: make(chan error),
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, errors.Wrapf(err, "Failed to create the offset store directory %s", base)
+	}
+
+	s := &localOffsetStore{
+		printer:      printer,
+		dir:          base,
+		segmentPath:  filepath.Join(base, segmentFileName),
+		snapshotPath: filepath.Join(base, snapshotFileName),
+		// Buffered and never blocked on: a slow reader must not be able to stall the
+		// writer goroutine or deadlock the fsync ticker.
+		writeErrors: make(chan error, 100),
 		in:          make(chan *progress, 100),
+		flush:       make(chan chan struct{}),
 		offsets:     make(map[string]map[int32]int64),
-	}, nil
+	}
+
+	offsets, err := s.replay()
+	if err != nil {
+		return nil, err
+	}
+	s.offsets = offsets
+
+	segment, err := os.OpenFile(s.segmentPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open the WAL segment %s", s.segmentPath)
+	}
+	s.segment = segment
+
+	s.start()
+	return s, nil
 }
 
 func (s *localOffsetStore) start() {
 	s.wg.Add(1)
-	ticker := time.NewTicker(3 * time.Second)
+	ticker := time.NewTicker(fsyncInterval)
 	go func() {
 		defer s.wg.Done()
 		for {
 			select {
 			case <-ticker.C:
-				s.writeOffsetsToDisk()
+				s.fsync()
+			case done, more := <-s.flush:
+				if !more {
+					ticker.Stop()
+					s.fsync()
+					return
+				}
+				// Append whatever Store queued before Flush was called, so this fsync covers it.
+				for n := len(s.in); n > 0; n-- {
+					p, ok := <-s.in
+					if !ok {
+						break
+					}
+					if err := s.append(p); err != nil {
+						s.reportError(err)
+					}
+				}
+				s.fsync()
+				close(done)
 			case p, more := <-s.in:
 				if !more {
 					ticker.Stop()
-					s.printer.Log(internal.Verbose, "Flushing the offsets to disk.")
-					s.writeOffsetsToDisk()
+					s.fsync()
 					return
 				}
-				_, ok := s.offsets[p.topic]
-				if !ok {
-					s.offsets[p.topic] = make(map[int32]int64)
+				if err := s.append(p); err != nil {
+					s.reportError(err)
+					continue
+				}
+				if s.recordsWritten >= compactAfterRecords {
+					if err := s.compact(); err != nil {
+						s.reportError(err)
+					}
 				}
-				s.offsets[p.topic][p.partition] = p.offset
 			}
 		}
 	}()
 }
 
-// Returns the channel on which the write errors will be received.
-// You must listen to this channel to avoid deadlock.
-func (s *localOffsetStore) errors() <-chan error {
+// reportError delivers err on the errors channel without ever blocking the writer goroutine;
+// an error nobody is listening for is dropped rather than allowed to deadlock the ticker.
+func (s *localOffsetStore) reportError(err error) {
+	select {
+	case s.writeErrors <- err:
+	default:
+		s.printer.Logf(internal.Forced, "Offset store error channel is full, dropping: %s", err)
+	}
+}
+
+// Errors returns the channel on which the write errors will be received.
+func (s *localOffsetStore) Errors() <-chan error {
 	return s.writeErrors
 }
 
-// Store saves the topic offset to the local disk.
+// Store appends the topic's partition offset to the WAL.
 func (s *localOffsetStore) Store(topic string, partition int32, offset int64) error {
 	if offset == sarama.OffsetOldest || offset == sarama.OffsetNewest {
 		return nil
@@ -95,60 +175,227 @@ func (s *localOffsetStore) Store(topic string, partition int32, offset int64) er
 	return nil
 }
 
-// Query loads the offsets of all the available partitions from the local disk.
+// Query returns the latest known offsets of every partition of topic from the in-memory map,
+// which NewDiskOffsetStore seeds by replaying the snapshot and the WAL tail written since, and
+// every appended record keeps up to date.
 func (s *localOffsetStore) Query(topic string) (map[int32]int64, error) {
-	offsets := make(map[int32]int64)
-	val, err := s.db.Read(topic)
-	if err != nil {
-		if os.IsNotExist(err) {
-			s.offsets[topic] = offsets
-			return offsets, nil
-		}
-		return nil, err
+	s.offsetsMu.RLock()
+	defer s.offsetsMu.RUnlock()
+	offsets, ok := s.offsets[topic]
+	if !ok {
+		return make(map[int32]int64), nil
+	}
+	result := make(map[int32]int64, len(offsets))
+	for p, o := range offsets {
+		result[p] = o
 	}
+	return result, nil
+}
 
-	buff := bytes.NewBuffer(val)
-	dec := gob.NewDecoder(buff)
-	err = dec.Decode(&offsets)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to deserialize the value from local offset store for topic %s", topic)
+// Flush blocks until every offset update queued so far has been fsync'd to disk, or ctx is
+// cancelled. The main loop calls this before exiting on SIGINT so in-flight offsets aren't lost.
+func (s *localOffsetStore) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case s.flush <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	s.offsets[topic] = offsets
-	return offsets, nil
 }
 
-func (s *localOffsetStore) close() {
-	if s == nil || s.db == nil {
-		return
+// Close flushes the WAL, fsyncs and closes the segment file, and stops the background writer.
+func (s *localOffsetStore) Close() error {
+	if s == nil || s.segment == nil {
+		return nil
 	}
 	s.printer.Log(internal.SuperVerbose, "Closing the offset store.")
 	close(s.in)
 	s.wg.Wait()
 	close(s.writeErrors)
+	err := s.segment.Close()
 	s.printer.Log(internal.SuperVerbose, "The offset store has been closed successfully.")
+	return err
 }
 
-func (s *localOffsetStore) writeOffsetsToDisk() {
-	for topic, offsets := range s.offsets {
-		buff := bytes.Buffer{}
-		enc := gob.NewEncoder(&buff)
-		toWrite := make(map[int32]int64)
-		for p, o := range offsets {
-			if o != sarama.OffsetNewest && o != sarama.OffsetOldest {
-				toWrite[p] = o
-			}
+// append writes a single fixed-layout record to the WAL segment:
+// {topic_len uint32}{topic []byte}{partition int32}{offset int64}{crc32 uint32}.
+func (s *localOffsetStore) append(p *progress) error {
+	buf := encodeRecord(p)
+	if _, err := s.segment.Write(buf); err != nil {
+		return errors.Wrapf(err, "Failed to append the offset of partition %d of topic %s to the WAL", p.partition, p.topic)
+	}
+
+	s.offsetsMu.Lock()
+	if _, ok := s.offsets[p.topic]; !ok {
+		s.offsets[p.topic] = make(map[int32]int64)
+	}
+	s.offsets[p.topic][p.partition] = p.offset
+	s.offsetsMu.Unlock()
+
+	s.recordsWritten++
+	s.pendingSync++
+	if s.pendingSync >= fsyncRecords {
+		s.fsync()
+	}
+	return nil
+}
+
+func (s *localOffsetStore) fsync() {
+	if s.pendingSync == 0 {
+		return
+	}
+	if err := s.segment.Sync(); err != nil {
+		s.reportError(errors.Wrap(err, "Failed to fsync the WAL segment"))
+		return
+	}
+	s.pendingSync = 0
+}
+
+// compact snapshots the current in-memory offsets to a new file and atomically swaps it over
+// the previous snapshot with os.Rename, then truncates the WAL segment since everything it held
+// is now captured by the snapshot.
+func (s *localOffsetStore) compact() error {
+	s.fsync()
+
+	tmp := s.snapshotPath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create the snapshot temp file")
+	}
+	s.offsetsMu.RLock()
+	err = gob.NewEncoder(f).Encode(s.offsets)
+	s.offsetsMu.RUnlock()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "Failed to serialise the offsets snapshot")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Failed to fsync the snapshot temp file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "Failed to close the snapshot temp file")
+	}
+	if err := os.Rename(tmp, s.snapshotPath); err != nil {
+		return errors.Wrap(err, "Failed to atomically replace the offsets snapshot")
+	}
+
+	if err := s.segment.Close(); err != nil {
+		return errors.Wrap(err, "Failed to close the WAL segment before truncating it")
+	}
+	segment, err := os.OpenFile(s.segmentPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "Failed to re-open the WAL segment after compaction")
+	}
+	s.segment = segment
+	s.recordsWritten = 0
+
+	s.printer.Log(internal.SuperVerbose, "Compacted the offset store into a new snapshot.")
+	return nil
+}
+
+// replay rebuilds the full offsets map by reading the last snapshot, if any, and then the WAL
+// tail written since. A truncated final record (a crash mid-append) is dropped rather than
+// treated as fatal.
+func (s *localOffsetStore) replay() (map[string]map[int32]int64, error) {
+	offsets := make(map[string]map[int32]int64)
+
+	if f, err := os.Open(s.snapshotPath); err == nil {
+		err := gob.NewDecoder(f).Decode(&offsets)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to deserialise the offsets snapshot")
 		}
-		if len(toWrite) == 0 {
-			return
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "Failed to open the offsets snapshot")
+	}
+
+	f, err := os.Open(s.segmentPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return offsets, nil
 		}
-		err := enc.Encode(toWrite)
-		if err != nil {
-			s.writeErrors <- errors.Wrapf(err, "Failed to serialise the offsets of topic %s", topic)
+		return nil, errors.Wrap(err, "Failed to open the WAL segment")
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var good int64
+	for {
+		p, err := decodeRecord(reader)
+		if err == io.EOF {
+			break
 		}
-		s.printer.Logf(internal.SuperVerbose, "Writing the offset(s) of topic %s to the disk %v.", topic, toWrite)
-		err = s.db.Write(topic, buff.Bytes())
 		if err != nil {
-			s.writeErrors <- errors.Wrapf(err, "Failed to write the offsets of topic %s to the disk %v", topic, toWrite)
+			s.printer.Logf(internal.Verbose, "Dropping the truncated tail of the WAL segment: %s", err)
+			break
+		}
+		good += int64(4 + len(p.topic) + 4 + 8 + 4)
+		if _, ok := offsets[p.topic]; !ok {
+			offsets[p.topic] = make(map[int32]int64)
+		}
+		offsets[p.topic][p.partition] = p.offset
+	}
+	// Cut the dropped tail off the segment, so records appended after it stay readable.
+	if info, err := f.Stat(); err == nil && info.Size() > good {
+		if err := os.Truncate(s.segmentPath, good); err != nil {
+			return nil, errors.Wrap(err, "Failed to truncate the dropped tail of the WAL segment")
 		}
 	}
+	return offsets, nil
+}
+
+func encodeRecord(p *progress) []byte {
+	topic := []byte(p.topic)
+	buf := make([]byte, 4+len(topic)+4+8+4)
+	offset := 0
+
+	binary.BigEndian.PutUint32(buf[offset:], uint32(len(topic)))
+	offset += 4
+	copy(buf[offset:], topic)
+	offset += len(topic)
+	binary.BigEndian.PutUint32(buf[offset:], uint32(p.partition))
+	offset += 4
+	binary.BigEndian.PutUint64(buf[offset:], uint64(p.offset))
+	offset += 8
+
+	checksum := crc32.ChecksumIEEE(buf[:offset])
+	binary.BigEndian.PutUint32(buf[offset:], checksum)
+
+	return buf
+}
+
+func decodeRecord(r *bufio.Reader) (*progress, error) {
+	var topicLenBuf [4]byte
+	if _, err := io.ReadFull(r, topicLenBuf[:]); err != nil {
+		return nil, err
+	}
+	topicLen := binary.BigEndian.Uint32(topicLenBuf[:])
+
+	body := make([]byte, topicLen+4+8)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, io.EOF
+	}
+
+	var crcBuf [4]byte
+	if _, err := io.ReadFull(r, crcBuf[:]); err != nil {
+		return nil, io.EOF
+	}
+
+	record := append(append([]byte{}, topicLenBuf[:]...), body...)
+	if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(crcBuf[:]) {
+		return nil, errors.New("WAL record failed its CRC32 check")
+	}
+
+	topic := string(body[:topicLen])
+	partition := int32(binary.BigEndian.Uint32(body[topicLen : topicLen+4]))
+	offset := int64(binary.BigEndian.Uint64(body[topicLen+4:]))
+
+	return &progress{topic: topic, partition: partition, offset: offset}, nil
 }
@@ -0,0 +1,25 @@
+package kafka
+
+import "context"
+
+// Flusher is implemented by OffsetStore backends that buffer writes in memory and can be
+// asked to synchronously push them out before shutdown. NewDiskOffsetStore's WAL,
+// KafkaOffsetStore and SQLOffsetStore all implement it.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// OffsetStore persists and retrieves the progress Trubka has made through each topic's
+// partitions, so a restarted instance can resume roughly where it left off.
+type OffsetStore interface {
+	// Store records that the given partition of topic has progressed to offset.
+	Store(topic string, partition int32, offset int64) error
+	// Query returns the previously stored offsets for every partition of topic.
+	Query(topic string) (map[int32]int64, error)
+	// Errors returns the channel on which asynchronous write errors are delivered. The
+	// channel is buffered and never blocks the store's background writer; errors that
+	// arrive while it is full are dropped.
+	Errors() <-chan error
+	// Close flushes any outstanding writes and releases the store's resources.
+	Close() error
+}
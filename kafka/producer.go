@@ -0,0 +1,179 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+
+	"github.com/xitonix/trubka/internal"
+)
+
+// ProducerOption represents a configuration function for the producer.
+type ProducerOption func(*Producer)
+
+// WithProducerClusterVersion sets the Kafka cluster version the client will negotiate against.
+func WithProducerClusterVersion(version string) ProducerOption {
+	return func(p *Producer) {
+		if internal.IsEmpty(version) {
+			return
+		}
+		v, err := sarama.ParseKafkaVersion(version)
+		if err != nil {
+			return
+		}
+		p.config.Version = v
+	}
+}
+
+// WithProducerTLS enables TLS on the underlying Kafka client connection.
+func WithProducerTLS(tlsConfig *tls.Config) ProducerOption {
+	return func(p *Producer) {
+		if tlsConfig == nil {
+			return
+		}
+		p.config.Net.TLS.Enable = true
+		p.config.Net.TLS.Config = tlsConfig
+	}
+}
+
+// WithProducerSASL configures SASL authentication on the producer, mirroring the consumer's
+// WithSASL/WithSASLOAuthBearer options.
+func WithProducerSASL(mechanism string, username string, password string) ProducerOption {
+	return func(p *Producer) {
+		if internal.IsEmpty(mechanism) {
+			return
+		}
+		p.config.Net.SASL.Enable = true
+		p.config.Net.SASL.Mechanism = sarama.SASLMechanism(mechanism)
+		p.config.Net.SASL.User = username
+		p.config.Net.SASL.Password = password
+		if generator := scramClientGeneratorFunc(mechanism); generator != nil {
+			p.config.Net.SASL.SCRAMClientGeneratorFunc = generator
+		}
+	}
+}
+
+// WithProducerSASLOAuthBearer configures OAUTHBEARER SASL authentication on the producer,
+// mirroring the consumer's WithSASLOAuthBearer.
+func WithProducerSASLOAuthBearer(provider sarama.AccessTokenProvider) ProducerOption {
+	return func(p *Producer) {
+		if provider == nil {
+			return
+		}
+		p.config.Net.SASL.Enable = true
+		p.config.Net.SASL.Mechanism = sarama.SASLTypeOAuth
+		p.config.Net.SASL.TokenProvider = provider
+	}
+}
+
+// WithPartitioner selects the partitioning strategy used to route messages with no explicit
+// partition: "hash" (the default, keyed on the message key), "roundrobin", or "manual".
+func WithPartitioner(kind string) ProducerOption {
+	return func(p *Producer) {
+		switch strings.ToLower(kind) {
+		case "roundrobin":
+			p.config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+		case "manual":
+			p.config.Producer.Partitioner = sarama.NewManualPartitioner
+		default:
+			p.config.Producer.Partitioner = sarama.NewHashPartitioner
+		}
+	}
+}
+
+// WithCompression sets the compression codec applied to produced messages.
+func WithCompression(codec string) ProducerOption {
+	return func(p *Producer) {
+		switch strings.ToLower(codec) {
+		case "gzip":
+			p.config.Producer.Compression = sarama.CompressionGZIP
+		case "snappy":
+			p.config.Producer.Compression = sarama.CompressionSnappy
+		case "lz4":
+			p.config.Producer.Compression = sarama.CompressionLZ4
+		case "zstd":
+			p.config.Producer.Compression = sarama.CompressionZSTD
+		default:
+			p.config.Producer.Compression = sarama.CompressionNone
+		}
+	}
+}
+
+// WithRequiredAcks sets how many broker acknowledgements the producer waits for: "none",
+// "leader" (the default) or "all".
+func WithRequiredAcks(acks string) ProducerOption {
+	return func(p *Producer) {
+		switch strings.ToLower(acks) {
+		case "none":
+			p.config.Producer.RequiredAcks = sarama.NoResponse
+		case "all":
+			p.config.Producer.RequiredAcks = sarama.WaitForAll
+		default:
+			p.config.Producer.RequiredAcks = sarama.WaitForLocal
+		}
+	}
+}
+
+// Producer publishes messages to Kafka topics.
+type Producer struct {
+	printer internal.Printer
+	config  *sarama.Config
+	sync    sarama.SyncProducer
+}
+
+// NewProducer creates a new synchronous Kafka producer.
+func NewProducer(brokers []string, printer internal.Printer, options ...ProducerOption) (*Producer, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "trubka"
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+
+	p := &Producer{
+		printer: printer,
+		config:  config,
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	sp, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to connect to the Kafka cluster")
+	}
+	p.sync = sp
+
+	return p, nil
+}
+
+// Send publishes value to topic, with an optional key, headers and explicit partition
+// (ignored unless the producer was configured with WithPartitioner("manual")). It returns the
+// partition and offset the message was written to.
+func (p *Producer) Send(topic string, key []byte, value []byte, headers map[string]string, partition int32) (int32, int64, error) {
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(value),
+	}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	// Hash/round-robin partitioners ignore msg.Partition; manual partitioning needs it set.
+	msg.Partition = partition
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+
+	partition, offset, err := p.sync.SendMessage(msg)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "Failed to publish the message to topic %s", topic)
+	}
+	return partition, offset, nil
+}
+
+// Close shuts down the underlying Kafka producer.
+func (p *Producer) Close() error {
+	return p.sync.Close()
+}
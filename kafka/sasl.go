@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"github.com/xdg-go/scram"
+)
+
+var sha256Generator scram.HashGeneratorFcn = func() hash.Hash { return sha256.New() }
+var sha512Generator scram.HashGeneratorFcn = func() hash.Hash { return sha512.New() }
+
+// xdgSCRAMClient adapts xdg-go/scram to sarama's SCRAMClient interface.
+type xdgSCRAMClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (c *xdgSCRAMClient) Begin(userName, password, authzID string) error {
+	client, err := c.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return errors.Wrap(err, "Failed to start the SCRAM handshake")
+	}
+	c.Client = client
+	c.ClientConversation = c.Client.NewConversation()
+	return nil
+}
+
+func (c *xdgSCRAMClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *xdgSCRAMClient) Done() bool {
+	return c.ClientConversation.Done()
+}
+
+// scramClientGeneratorFunc returns the sarama.SCRAMClientGeneratorFunc matching the given
+// mechanism, or nil if the mechanism is not a SCRAM variant.
+func scramClientGeneratorFunc(algorithm string) func() sarama.SCRAMClient {
+	switch strings.ToUpper(algorithm) {
+	case "SCRAM-SHA-256":
+		return func() sarama.SCRAMClient {
+			return &xdgSCRAMClient{HashGeneratorFcn: sha256Generator}
+		}
+	case "SCRAM-SHA-512":
+		return func() sarama.SCRAMClient {
+			return &xdgSCRAMClient{HashGeneratorFcn: sha512Generator}
+		}
+	default:
+		return nil
+	}
+}
+
+// WithSASLOAuthBearer configures OAUTHBEARER SASL authentication, fetching the access token
+// from the given provider on every connection and re-authentication attempt.
+func WithSASLOAuthBearer(provider sarama.AccessTokenProvider) Option {
+	return func(c *Consumer) {
+		if provider == nil {
+			return
+		}
+		c.config.Net.SASL.Enable = true
+		c.config.Net.SASL.Mechanism = sarama.SASLTypeOAuth
+		c.config.Net.SASL.TokenProvider = provider
+	}
+}
+
+// staticTokenProvider reads a fresh OAuth bearer token from a file on every call, so the
+// token can be rotated on disk without restarting Trubka.
+type staticTokenProvider struct {
+	path string
+}
+
+// NewFileTokenProvider creates a sarama.AccessTokenProvider that reads the bearer token from path.
+func NewFileTokenProvider(path string) sarama.AccessTokenProvider {
+	return &staticTokenProvider{path: path}
+}
+
+func (p *staticTokenProvider) Token() (*sarama.AccessToken, error) {
+	content, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read the SASL token file %s", p.path)
+	}
+	return &sarama.AccessToken{Token: strings.TrimSpace(string(content))}, nil
+}
+
+// commandTokenProvider runs an external command and treats its trimmed stdout as the bearer
+// token, so the token can be sourced from an STS, a vault agent, or any other issuer.
+type commandTokenProvider struct {
+	command string
+}
+
+// NewCommandTokenProvider creates a sarama.AccessTokenProvider that executes command through
+// the shell and uses its standard output as the bearer token.
+func NewCommandTokenProvider(command string) sarama.AccessTokenProvider {
+	return &commandTokenProvider{command: command}
+}
+
+func (p *commandTokenProvider) Token() (*sarama.AccessToken, error) {
+	out, err := exec.Command("sh", "-c", p.command).Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to run the SASL token command %s", p.command)
+	}
+	return &sarama.AccessToken{Token: strings.TrimSpace(string(out))}, nil
+}
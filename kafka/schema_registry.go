@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// confluentMagicByte is the leading byte Confluent's Schema Registry wire format prefixes
+// every message with, followed by a 4-byte big-endian schema ID.
+const confluentMagicByte = 0x0
+
+// schemaEntry is a single schema fetched from the registry, cached by ID.
+type schemaEntry struct {
+	ID     int
+	Schema string
+}
+
+// SchemaRegistryClient fetches and caches schemas from a Confluent-compatible Schema Registry.
+type SchemaRegistryClient struct {
+	baseURL string
+	auth    string
+	client  *http.Client
+
+	mu    sync.RWMutex
+	cache map[int]*schemaEntry
+}
+
+// NewSchemaRegistryClient creates a client for the registry at baseURL. auth, if non-empty, is
+// sent as the value of the HTTP Authorization header on every request.
+func NewSchemaRegistryClient(baseURL string, auth string) *SchemaRegistryClient {
+	return &SchemaRegistryClient{
+		baseURL: baseURL,
+		auth:    auth,
+		client:  http.DefaultClient,
+		cache:   make(map[int]*schemaEntry),
+	}
+}
+
+// Schema returns the raw schema registered under id, fetching it from the registry on the
+// first request and serving every subsequent one from the in-memory cache.
+func (r *SchemaRegistryClient) Schema(id int) (string, error) {
+	r.mu.RLock()
+	entry, ok := r.cache[id]
+	r.mu.RUnlock()
+	if ok {
+		return entry.Schema, nil
+	}
+
+	url := fmt.Sprintf("%s/schemas/ids/%d", r.baseURL, id)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to build the schema registry request for ID %d", id)
+	}
+	if r.auth != "" {
+		req.Header.Set("Authorization", r.auth)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to fetch the schema with ID %d", id)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to read the schema registry response for ID %d", id)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("the schema registry returned %d for ID %d: %s", resp.StatusCode, id, body)
+	}
+
+	var parsed struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", errors.Wrapf(err, "Failed to parse the schema registry response for ID %d", id)
+	}
+
+	r.mu.Lock()
+	r.cache[id] = &schemaEntry{ID: id, Schema: parsed.Schema}
+	r.mu.Unlock()
+
+	return parsed.Schema, nil
+}
+
+// splitConfluentEnvelope strips the 5-byte Confluent wire-format prefix from value, returning
+// the schema ID it encodes and the remaining payload.
+func splitConfluentEnvelope(value []byte) (int, []byte, error) {
+	if len(value) < 5 || value[0] != confluentMagicByte {
+		return 0, nil, errors.New("the message does not start with the Confluent schema registry magic byte")
+	}
+	id := int(binary.BigEndian.Uint32(value[1:5]))
+	return id, value[5:], nil
+}
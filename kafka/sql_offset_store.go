@@ -0,0 +1,198 @@
+package kafka
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/xitonix/trubka/internal"
+)
+
+// sqlCommitInterval caps how long a Store call can sit uncommitted: Store only buffers the
+// latest offset per partition, and a background goroutine writes whatever is buffered on this
+// tick instead of issuing an upsert on every single call.
+const sqlCommitInterval = 3 * time.Second
+
+// sqlDialect hides the differences between the `database/sql` drivers SQLOffsetStore
+// supports: placeholder syntax and how an upsert is expressed.
+type sqlDialect struct {
+	placeholder func(n int) string
+	upsert      string
+}
+
+var sqlDialects = map[string]sqlDialect{
+	"postgres": {
+		placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+		upsert: `INSERT INTO trubka_offsets (topic, partition, offset) VALUES ($1, $2, $3)
+			ON CONFLICT (topic, partition) DO UPDATE SET offset = excluded.offset`,
+	},
+	"mysql": {
+		placeholder: func(int) string { return "?" },
+		upsert: `INSERT INTO trubka_offsets (topic, partition, offset) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE offset = VALUES(offset)`,
+	},
+}
+
+// SQLOffsetStore is an OffsetStore backed by a `database/sql` connection, so offset progress
+// can be shared across machines via a Postgres or MySQL database instead of local disk.
+type SQLOffsetStore struct {
+	printer     internal.Printer
+	db          *sql.DB
+	dialect     sqlDialect
+	writeErrors chan error
+
+	mu      sync.Mutex
+	pending map[string]map[int32]int64
+
+	flush chan chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewSQLOffsetStore creates an OffsetStore backed by the database identified by dsn, opened
+// through the given driver ("postgres" or "mysql"). It creates its offsets table if one does
+// not already exist.
+func NewSQLOffsetStore(printer internal.Printer, driver string, dsn string) (OffsetStore, error) {
+	dialect, ok := sqlDialects[driver]
+	if !ok {
+		return nil, errors.Errorf("Unsupported SQL offset store driver %s", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open the %s offset store", driver)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to connect to the %s offset store", driver)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS trubka_offsets (
+		topic     VARCHAR(255) NOT NULL,
+		partition INTEGER      NOT NULL,
+		offset    BIGINT       NOT NULL,
+		PRIMARY KEY (topic, partition)
+	)`)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create the offsets table")
+	}
+
+	s := &SQLOffsetStore{
+		printer:     printer,
+		db:          db,
+		dialect:     dialect,
+		writeErrors: make(chan error, 100),
+		pending:     make(map[string]map[int32]int64),
+		flush:       make(chan chan struct{}),
+	}
+	s.start()
+	return s, nil
+}
+
+func (s *SQLOffsetStore) start() {
+	s.wg.Add(1)
+	ticker := time.NewTicker(sqlCommitInterval)
+	go func() {
+		defer s.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.writePending()
+			case done, more := <-s.flush:
+				if !more {
+					s.writePending()
+					return
+				}
+				s.writePending()
+				close(done)
+			}
+		}
+	}()
+}
+
+// Store buffers the partition's offset in memory; it is upserted into the offsets table by the
+// background goroutine every sqlCommitInterval, rather than on every call.
+func (s *SQLOffsetStore) Store(topic string, partition int32, offset int64) error {
+	s.mu.Lock()
+	if s.pending[topic] == nil {
+		s.pending[topic] = make(map[int32]int64)
+	}
+	s.pending[topic][partition] = offset
+	s.mu.Unlock()
+	return nil
+}
+
+// writePending upserts everything buffered by Store since the last tick, reporting any failure
+// on writeErrors instead of blocking the caller that buffered it.
+func (s *SQLOffsetStore) writePending() {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]map[int32]int64)
+	s.mu.Unlock()
+
+	for topic, partitions := range pending {
+		for partition, offset := range partitions {
+			if _, err := s.db.Exec(s.dialect.upsert, topic, partition, offset); err != nil {
+				err = errors.Wrapf(err, "Failed to store the offset of partition %d of topic %s", partition, topic)
+				select {
+				case s.writeErrors <- err:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// Flush blocks until every offset buffered by Store so far has been written.
+func (s *SQLOffsetStore) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case s.flush <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Query loads the stored offsets of every partition of topic.
+func (s *SQLOffsetStore) Query(topic string) (map[int32]int64, error) {
+	query := fmt.Sprintf("SELECT partition, offset FROM trubka_offsets WHERE topic = %s", s.dialect.placeholder(1))
+	rows, err := s.db.Query(query, topic)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to query the offsets of topic %s", topic)
+	}
+	defer rows.Close()
+
+	offsets := make(map[int32]int64)
+	for rows.Next() {
+		var partition int32
+		var offset int64
+		if err := rows.Scan(&partition, &offset); err != nil {
+			return nil, errors.Wrapf(err, "Failed to read the offsets of topic %s", topic)
+		}
+		offsets[partition] = offset
+	}
+	return offsets, rows.Err()
+}
+
+// Errors returns the channel on which asynchronous write errors are delivered.
+func (s *SQLOffsetStore) Errors() <-chan error {
+	return s.writeErrors
+}
+
+// Close releases the underlying database connection pool.
+func (s *SQLOffsetStore) Close() error {
+	close(s.flush)
+	s.wg.Wait()
+	return s.db.Close()
+}
@@ -14,7 +14,6 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 	"github.com/pkg/profile"
 	"github.com/xitonix/flags/core"
@@ -28,6 +27,11 @@ var version string
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "produce" {
+		runProduce(os.Args[2:])
+		return
+	}
+
 	initFlags()
 
 	if versionRequest.Get() {
@@ -83,13 +87,29 @@ func main() {
 		}
 	}
 
+	consumerOptions := []kafka.Option{
+		kafka.WithClusterVersion(kafkaVersion.Get()),
+		kafka.WithTLS(tlsConfig),
+		configureSASL(),
+		kafka.WithConsumerGroup(consumerGroup.Get()),
+	}
+
+	offsetStore, err := configureOffsetStore(prn)
+	if err != nil {
+		exit(err)
+	}
+	if offsetStore != nil {
+		if consumerGroup.IsSet() {
+			exit(errors.New("--offset-store cannot be combined with --consumer-group: consumer-group mode always commits through the group session"))
+		}
+		consumerOptions = append(consumerOptions, kafka.WithOffsetStore(offsetStore))
+	}
+
 	consumer, err := kafka.NewConsumer(
 		brokers.Get(), prn,
 		environment.Get(),
 		enableAutoTopicCreation.Get(),
-		kafka.WithClusterVersion(kafkaVersion.Get()),
-		kafka.WithTLS(tlsConfig),
-		kafka.WithSASL(saslMechanism.Get(), saslUsername.Get(), saslPassword.Get()))
+		consumerOptions...)
 
 	if err != nil {
 		exit(err)
@@ -119,10 +139,15 @@ func main() {
 		topics = getTopics(tm, cp)
 	}
 
-	for _, messageType := range tm {
-		err := loader.Load(messageType)
-		if err != nil {
-			exit(err)
+	// Only the protobuf decoder needs a message type pre-loaded from the .proto sources; the
+	// schema-registry backed decoders resolve their schema from each message's own wire-format
+	// prefix instead, and tm's values are meaningless to them.
+	if isProtobufDecoder(decoderType.Get()) {
+		for _, messageType := range tm {
+			err := loader.Load(messageType)
+			if err != nil {
+				exit(err)
+			}
 		}
 	}
 
@@ -139,10 +164,16 @@ func main() {
 		wg.Add(1)
 		consumerCtx, stopConsumer := context.WithCancel(context.Background())
 		defer stopConsumer()
+		marshaller := protobuf.NewMarshaller(format.Get(), includeTimeStamp.Get())
+		registry := configureSchemaRegistry()
+		decoder, err := kafka.NewDecoder(decoderType.Get(), loader, marshaller, registry)
+		if err != nil {
+			exit(err)
+		}
+
 		go func() {
 			defer wg.Done()
 			reversed := reverse.Get()
-			marshaller := protobuf.NewMarshaller(format.Get(), includeTimeStamp.Get())
 			var cancelled bool
 			for {
 				select {
@@ -160,7 +191,7 @@ func main() {
 						// Otherwise the consumer will deadlock
 						continue
 					}
-					output, err := process(tm[event.Topic], loader, event, marshaller, searchExpression, reversed)
+					output, err := process(tm[event.Topic], decoder, event, searchExpression, reversed)
 					if err == nil {
 						prn.WriteEvent(event.Topic, output)
 						consumer.StoreOffset(event)
@@ -172,6 +203,11 @@ func main() {
 						event.Partition,
 						event.Topic,
 						err)
+				case rb, more := <-consumer.Rebalances():
+					if !more {
+						continue
+					}
+					prn.Logf(internal.Verbose, "Consumer group %s rebalanced: %+v", rb.GroupID, rb)
 				}
 			}
 		}()
@@ -246,6 +282,53 @@ func configureTLS() (*tls.Config, error) {
 	return &tlsConf, nil
 }
 
+// configureSASL turns the --sasl-* flags into the matching kafka.Option. OAUTHBEARER is wired
+// up with a token provider (a static token file or an exec'd command); every other mechanism,
+// including the SCRAM variants, goes through the regular username/password option.
+func configureSASL() kafka.Option {
+	mechanism := strings.ToUpper(saslMechanism.Get())
+	if mechanism != "OAUTHBEARER" {
+		algorithm := saslScramAlgorithm.Get()
+		if !internal.IsEmpty(algorithm) {
+			mechanism = algorithm
+		}
+		return kafka.WithSASL(mechanism, saslUsername.Get(), saslPassword.Get())
+	}
+
+	switch {
+	case saslTokenCommand.IsSet():
+		return kafka.WithSASLOAuthBearer(kafka.NewCommandTokenProvider(saslTokenCommand.Get()))
+	case saslTokenFile.IsSet():
+		return kafka.WithSASLOAuthBearer(kafka.NewFileTokenProvider(saslTokenFile.Get()))
+	default:
+		return kafka.WithSASL(mechanism, saslUsername.Get(), saslPassword.Get())
+	}
+}
+
+// configureOffsetStore turns the --offset-store flag into the matching kafka.OffsetStore. A
+// nil, nil return leaves the consumer to fall back to its default disk-backed store.
+//
+// The "kafka" store is deliberately keyed off its own --offset-store-group flag rather than
+// --consumer-group: the latter switches the whole Consumer into consumer-group mode (see
+// WithConsumerGroup), whose startGroup path commits offsets through the group session and
+// never looks at the injected OffsetStore at all. --offset-store-group lets a standalone
+// instance still park its progress in `__consumer_offsets` without joining a shared group.
+func configureOffsetStore(prn internal.Printer) (kafka.OffsetStore, error) {
+	if !offsetStoreType.IsSet() {
+		return nil, nil
+	}
+	switch strings.ToLower(offsetStoreType.Get()) {
+	case "disk", "":
+		return nil, nil
+	case "kafka":
+		return kafka.NewKafkaOffsetStore(prn, brokers.Get(), offsetStoreGroup.Get(), nil)
+	case "postgres", "mysql":
+		return kafka.NewSQLOffsetStore(prn, strings.ToLower(offsetStoreType.Get()), offsetStoreDSN.Get())
+	default:
+		return nil, errors.Errorf("Unknown offset store type %s", offsetStoreType.Get())
+	}
+}
+
 func getCheckpoint(rewind bool, timeCheckpoint *core.TimeFlag, offsetCheckpoint *core.Int64Flag) *kafka.Checkpoint {
 	cp := kafka.NewCheckpoint(rewind)
 	switch {
@@ -265,23 +348,12 @@ func printVersion() {
 }
 
 func process(messageType string,
-	loader *protobuf.FileLoader,
+	decoder kafka.Decoder,
 	event *kafka.Event,
-	marshaller *protobuf.Marshaller,
 	search *regexp.Regexp,
 	reverse bool) ([]byte, error) {
 
-	msg, err := loader.Get(messageType)
-	if err != nil {
-		return nil, err
-	}
-
-	err = proto.Unmarshal(event.Value, msg)
-	if err != nil {
-		return nil, err
-	}
-
-	output, err := marshaller.Marshal(msg, event.Timestamp)
+	output, err := decoder.Decode(messageType, event.Value, event.Timestamp)
 	if err != nil {
 		return nil, err
 	}
@@ -293,6 +365,22 @@ func process(messageType string,
 	return output, nil
 }
 
+// isProtobufDecoder reports whether kind selects the protobuf decoder, which is also what an
+// unset --decoder defaults to.
+func isProtobufDecoder(kind string) bool {
+	return kind == "" || kind == "protobuf"
+}
+
+// configureSchemaRegistry builds a kafka.SchemaRegistryClient from the --schema-registry-*
+// flags, or returns nil when no registry URL has been configured (the protobuf decoder, and
+// the plain "avro" decoder, don't need one).
+func configureSchemaRegistry() *kafka.SchemaRegistryClient {
+	if !schemaRegistryURL.IsSet() {
+		return nil
+	}
+	return kafka.NewSchemaRegistryClient(schemaRegistryURL.Get(), schemaRegistryAuth.Get())
+}
+
 func getTopics(topicMap map[string]string, cp *kafka.Checkpoint) map[string]*kafka.Checkpoint {
 	topics := make(map[string]*kafka.Checkpoint)
 	for topic := range topicMap {
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+
+	"github.com/xitonix/trubka/internal"
+	"github.com/xitonix/trubka/kafka"
+	"github.com/xitonix/trubka/protobuf"
+)
+
+// produceFlags holds the parsed configuration of the `produce` subcommand. It duplicates the
+// TLS/SASL flags of the consume path rather than reading the global ones, because runProduce
+// never calls initFlags, so those globals are never parsed off os.Args for this subcommand.
+type produceFlags struct {
+	brokers      string
+	protoDir     string
+	protoFiles   string
+	messageType  string
+	topic        string
+	key          string
+	headers      string
+	partitioner  string
+	compression  string
+	acks         string
+	format       string
+	kafkaVersion string
+	inputFile    string
+	partition    int
+	verbosity    string
+
+	enableTLS  bool
+	caCert     string
+	clientCert string
+	clientKey  string
+
+	saslMechanism      string
+	saslUsername       string
+	saslPassword       string
+	saslScramAlgorithm string
+	saslTokenFile      string
+	saslTokenCommand   string
+}
+
+func parseProduceFlags(args []string) *produceFlags {
+	fs := flag.NewFlagSet("produce", flag.ExitOnError)
+	f := &produceFlags{}
+	fs.StringVar(&f.brokers, "brokers", "127.0.0.1:9092", "The comma separated list of Kafka broker addresses.")
+	fs.StringVar(&f.protoDir, "proto-root", "", "The path to the folder where the proto files live.")
+	fs.StringVar(&f.protoFiles, "proto-files", "", "The comma separated list of proto files to load, relative to proto-root.")
+	fs.StringVar(&f.messageType, "proto", "", "The fully qualified name of the protobuf message to publish.")
+	fs.StringVar(&f.topic, "topic", "", "The topic to publish the messages to.")
+	fs.StringVar(&f.key, "key", "", "The key to publish the messages with.")
+	fs.StringVar(&f.headers, "headers", "", "Comma separated list of key=value message headers.")
+	fs.StringVar(&f.partitioner, "partitioner", "hash", "The partitioning strategy: hash, roundrobin or manual.")
+	fs.IntVar(&f.partition, "partition", 0, "The partition to publish to, when --partitioner=manual.")
+	fs.StringVar(&f.compression, "compression", "none", "The compression codec: none, gzip, snappy, lz4 or zstd.")
+	fs.StringVar(&f.acks, "acks", "leader", "The number of acks the producer waits for: none, leader or all.")
+	fs.StringVar(&f.format, "format", "json", "The format each input line is encoded in: json or prototext.")
+	fs.StringVar(&f.kafkaVersion, "kafka-version", "", "The Kafka cluster version.")
+	fs.StringVar(&f.inputFile, "input-file", "", "Read messages from this file instead of stdin, one per line.")
+	fs.BoolVar(&f.enableTLS, "tls", false, "Enables TLS in the same way as the consume command.")
+	fs.StringVar(&f.caCert, "tls-ca-cert", "", "The path to the CA certificate file.")
+	fs.StringVar(&f.clientCert, "tls-client-cert", "", "The path to the client TLS certificate file.")
+	fs.StringVar(&f.clientKey, "tls-client-key", "", "The path to the client TLS key file.")
+	fs.StringVar(&f.saslMechanism, "sasl-mechanism", "", "The SASL mechanism: PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER.")
+	fs.StringVar(&f.saslUsername, "sasl-username", "", "The SASL username.")
+	fs.StringVar(&f.saslPassword, "sasl-password", "", "The SASL password.")
+	fs.StringVar(&f.saslScramAlgorithm, "sasl-scram-algorithm", "", "Alias for --sasl-mechanism when it is a SCRAM variant.")
+	fs.StringVar(&f.saslTokenFile, "sasl-token-file", "", "Path to a file holding the OAUTHBEARER access token.")
+	fs.StringVar(&f.saslTokenCommand, "sasl-token-command", "", "Command whose stdout is the OAUTHBEARER access token.")
+	fs.StringVar(&f.verbosity, "verbosity", "info", "The verbosity level of the output.")
+	fs.Parse(args)
+	return f
+}
+
+// configureProduceTLS builds the producer's TLS config from produce's own flags. It mirrors
+// configureTLS, which can't be reused directly since it reads the consume command's globals.
+func configureProduceTLS(f *produceFlags) (*tls.Config, error) {
+	var tlsConf tls.Config
+
+	if !internal.IsEmpty(f.clientCert) {
+		if internal.IsEmpty(f.clientKey) {
+			return nil, errors.New("TLS client key is missing. Mutual authentication cannot be used")
+		}
+		certificate, err := tls.LoadX509KeyPair(f.clientCert, f.clientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to load the client TLS key pair")
+		}
+		tlsConf.Certificates = []tls.Certificate{certificate}
+	}
+
+	if internal.IsEmpty(f.caCert) {
+		tlsConf.InsecureSkipVerify = true
+		return &tlsConf, nil
+	}
+	ca, err := ioutil.ReadFile(f.caCert)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read the CA certificate")
+	}
+	certPool := x509.NewCertPool()
+	if ok := certPool.AppendCertsFromPEM(ca); !ok {
+		return nil, errors.New("failed to append the CA certificate to the pool")
+	}
+	tlsConf.RootCAs = certPool
+
+	return &tlsConf, nil
+}
+
+// configureProduceSASL mirrors configureSASL for the produce subcommand's own flags, so the
+// SCRAM and OAUTHBEARER support added for the consumer is also available to the producer.
+func configureProduceSASL(f *produceFlags) kafka.ProducerOption {
+	mechanism := strings.ToUpper(f.saslMechanism)
+	if mechanism != "OAUTHBEARER" {
+		if !internal.IsEmpty(f.saslScramAlgorithm) {
+			mechanism = f.saslScramAlgorithm
+		}
+		return kafka.WithProducerSASL(mechanism, f.saslUsername, f.saslPassword)
+	}
+
+	switch {
+	case f.saslTokenCommand != "":
+		return kafka.WithProducerSASLOAuthBearer(kafka.NewCommandTokenProvider(f.saslTokenCommand))
+	case f.saslTokenFile != "":
+		return kafka.WithProducerSASLOAuthBearer(kafka.NewFileTokenProvider(f.saslTokenFile))
+	default:
+		return kafka.WithProducerSASL(mechanism, f.saslUsername, f.saslPassword)
+	}
+}
+
+// runProduce implements the `produce` subcommand: it reads one message per line from stdin
+// (or from --input-file if set), encodes each one as a protobuf message using the same
+// FileLoader the consume path uses, and publishes it to Kafka.
+func runProduce(args []string) {
+	f := parseProduceFlags(args)
+
+	prn := internal.NewPrinter(internal.ToVerbosityLevel(f.verbosity), os.Stdout)
+
+	var protoFiles []string
+	if f.protoFiles != "" {
+		protoFiles = strings.Split(f.protoFiles, ",")
+	}
+	loader, err := protobuf.NewFileLoader(f.protoDir, protoFiles...)
+	if err != nil {
+		exit(err)
+	}
+	if err := loader.Load(f.messageType); err != nil {
+		exit(err)
+	}
+
+	var tlsConfig *tls.Config
+	if f.enableTLS {
+		tlsConfig, err = configureProduceTLS(f)
+		if err != nil {
+			exit(err)
+		}
+	}
+
+	producer, err := kafka.NewProducer(
+		strings.Split(f.brokers, ","), prn,
+		kafka.WithProducerClusterVersion(f.kafkaVersion),
+		kafka.WithProducerTLS(tlsConfig),
+		configureProduceSASL(f),
+		kafka.WithPartitioner(f.partitioner),
+		kafka.WithCompression(f.compression),
+		kafka.WithRequiredAcks(f.acks))
+	if err != nil {
+		exit(err)
+	}
+	defer producer.Close()
+
+	headers := parseHeaders(f.headers)
+
+	var input io.Reader = os.Stdin
+	if f.inputFile != "" {
+		file, err := os.Open(f.inputFile)
+		if err != nil {
+			exit(errors.Wrap(err, "Failed to open the input file"))
+		}
+		defer file.Close()
+		input = file
+	}
+
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		msg, err := loader.Get(f.messageType)
+		if err != nil {
+			exit(err)
+		}
+
+		if err := unmarshalInput(line, msg, f.format); err != nil {
+			prn.Logf(internal.Forced, "Failed to parse the input message: %s", err)
+			continue
+		}
+
+		value, err := proto.Marshal(msg)
+		if err != nil {
+			prn.Logf(internal.Forced, "Failed to serialise the input message: %s", err)
+			continue
+		}
+
+		var key []byte
+		if f.key != "" {
+			key = []byte(f.key)
+		}
+		partition, offset, err := producer.Send(f.topic, key, value, headers, int32(f.partition))
+		if err != nil {
+			prn.Logf(internal.Forced, "Failed to publish the message: %s", err)
+			continue
+		}
+		prn.Logf(internal.Verbose, "Published to partition %d at offset %d.", partition, offset)
+	}
+
+	if err := scanner.Err(); err != nil {
+		exit(errors.Wrap(err, "Failed to read the input"))
+	}
+}
+
+// unmarshalInput decodes a single input line into msg, either as JSON or as prototext.
+func unmarshalInput(line string, msg proto.Message, format string) error {
+	if strings.EqualFold(format, "prototext") {
+		return proto.UnmarshalText(line, msg)
+	}
+	return jsonpb.UnmarshalString(line, msg)
+}
+
+// parseHeaders turns a comma separated list of "key=value" pairs into a header map.
+func parseHeaders(raw string) map[string]string {
+	headers := make(map[string]string)
+	if raw == "" {
+		return headers
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		headers[parts[0]] = parts[1]
+	}
+	return headers
+}